@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+func rmcmd(target string) string {
+	return fmt.Sprintf("rm -rf %s", target)
+}
+
+func mkdircmd(target string) string {
+	return fmt.Sprintf("mkdir -p %s", target)
+}
+
+// getRealPath converts a Windows-style path (as produced by MSYS/Cygwin tar
+// on some CI runners) into the form the local tar binary expects.
+func getRealPath(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+
+	path = strings.Replace(path, "\\", "/", -1)
+	if len(path) > 1 && path[1] == ':' {
+		path = "/" + strings.ToLower(string(path[0])) + path[2:]
+	}
+
+	return path
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Schemes recognised for Config.Host entries that name an object storage
+// destination instead of an ssh host:port.
+const (
+	destSchemeS3    = "s3"
+	destSchemeGCS   = "gs"
+	destSchemeAzure = "az"
+)
+
+// Destination ships the archive built for the "tar" transport to object
+// storage, for Config.Host entries written as s3://bucket/prefix,
+// gs://bucket/prefix or az://container/prefix. Credentials come from the
+// usual per-provider environment variables (AWS_*, GOOGLE_APPLICATION_CREDENTIALS,
+// AZURE_STORAGE_ACCOUNT/AZURE_STORAGE_KEY) rather than Config, so one
+// pipeline step can fan out to several providers at once.
+type Destination interface {
+	Send(ctx context.Context, localTar, key string) error
+	Remove(ctx context.Context, key string) error
+}
+
+// isBlobHost reports whether host is an object storage URL rather than an
+// ssh host:port entry.
+func isBlobHost(host string) bool {
+	u, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+
+	switch u.Scheme {
+	case destSchemeS3, destSchemeGCS, destSchemeAzure:
+		return true
+	default:
+		return false
+	}
+}
+
+// newDestination parses a Config.Host entry such as s3://bucket/prefix and
+// returns the backend that ships the tar archive there.
+func newDestination(ctx context.Context, host string, cfg Config) (Destination, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination url %q: %w", host, err)
+	}
+
+	bucket := u.Host
+	prefix := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case destSchemeS3:
+		return newS3Destination(bucket, prefix, cfg)
+	case destSchemeGCS:
+		return newGCSDestination(ctx, bucket, prefix, cfg)
+	case destSchemeAzure:
+		return newAzureDestination(bucket, prefix, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported destination scheme %q", u.Scheme)
+	}
+}
+
+// sendToDestination ships the tar archive built for the "tar" transport to
+// a Config.Host entry that names object storage rather than an ssh host.
+func (p *Plugin) sendToDestination(host, tar string) error {
+	if tar == "" {
+		return fmt.Errorf("object storage destinations require the default tar transport without streaming")
+	}
+
+	ctx := context.Background()
+
+	dest, err := newDestination(ctx, host, p.Config)
+	if err != nil {
+		return err
+	}
+
+	return p.sendToDest(ctx, dest, host, tar)
+}
+
+// sendToDest does the actual remove-then-send against an already
+// constructed Destination, split out from sendToDestination so it can be
+// exercised against a fake Destination without dialing a real provider.
+func (p *Plugin) sendToDest(ctx context.Context, dest Destination, host, tar string) error {
+	key := destinationKey(p.Config)
+
+	if p.Config.Remove {
+		p.log(host, "remove existing object", key)
+		if err := dest.Remove(ctx, key); err != nil {
+			p.log(host, "remove existing object failed, continuing:", err)
+		}
+	}
+
+	p.log(host, "send archive to object storage")
+
+	return dest.Send(ctx, tar, key)
+}
+
+// destinationKey is the stable object name uploads are written to, so
+// Remove on a later run finds the object a previous run uploaded instead
+// of that run's own randomly named local archive (which hasn't been
+// uploaded yet when Remove runs).
+func destinationKey(cfg Config) string {
+	key := "archive.tar.gz"
+	if cfg.Encrypt {
+		key += ".age"
+	}
+
+	return key
+}
+
+type s3Destination struct {
+	bucket, prefix string
+	cfg            Config
+	uploader       *s3manager.Uploader
+	client         *s3.S3
+}
+
+func newS3Destination(bucket, prefix string, cfg Config) (*s3Destination, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Destination{
+		bucket:   bucket,
+		prefix:   prefix,
+		cfg:      cfg,
+		uploader: s3manager.NewUploader(sess),
+		client:   s3.New(sess),
+	}, nil
+}
+
+func (d *s3Destination) Send(ctx context.Context, localTar, key string) error {
+	f, err := os.Open(localTar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(path.Join(d.prefix, key)),
+		Body:   f,
+	}
+
+	if d.cfg.SSE != "" {
+		input.ServerSideEncryption = aws.String(d.cfg.SSE)
+		if d.cfg.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(d.cfg.KMSKeyID)
+		}
+	}
+
+	if d.cfg.RetentionTag != "" {
+		input.Tagging = aws.String(d.cfg.RetentionTag)
+	}
+
+	_, err = d.uploader.UploadWithContext(ctx, input)
+
+	return err
+}
+
+func (d *s3Destination) Remove(ctx context.Context, key string) error {
+	_, err := d.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(path.Join(d.prefix, key)),
+	})
+
+	return err
+}
+
+type gcsDestination struct {
+	bucket, prefix string
+	cfg            Config
+	client         *storage.Client
+}
+
+func newGCSDestination(ctx context.Context, bucket, prefix string, cfg Config) (*gcsDestination, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcsDestination{bucket: bucket, prefix: prefix, cfg: cfg, client: client}, nil
+}
+
+func (d *gcsDestination) Send(ctx context.Context, localTar, key string) error {
+	f, err := os.Open(localTar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := d.client.Bucket(d.bucket).Object(path.Join(d.prefix, key)).NewWriter(ctx)
+	if d.cfg.KMSKeyID != "" {
+		w.KMSKeyName = d.cfg.KMSKeyID
+	}
+	if d.cfg.RetentionTag != "" {
+		w.Metadata = map[string]string{"retention": d.cfg.RetentionTag}
+	}
+
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+func (d *gcsDestination) Remove(ctx context.Context, key string) error {
+	return d.client.Bucket(d.bucket).Object(path.Join(d.prefix, key)).Delete(ctx)
+}
+
+type azureDestination struct {
+	prefix       string
+	cfg          Config
+	containerURL azblob.ContainerURL
+}
+
+func newAzureDestination(container, prefix string, cfg Config) (*azureDestination, error) {
+	accountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	accountKey := os.Getenv("AZURE_STORAGE_KEY")
+
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, container))
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+
+	return &azureDestination{
+		prefix:       prefix,
+		cfg:          cfg,
+		containerURL: azblob.NewContainerURL(*u, pipeline),
+	}, nil
+}
+
+func (d *azureDestination) Send(ctx context.Context, localTar, key string) error {
+	f, err := os.Open(localTar)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	metadata := azblob.Metadata{}
+	if d.cfg.RetentionTag != "" {
+		metadata["retention"] = d.cfg.RetentionTag
+	}
+
+	blobURL := d.containerURL.NewBlockBlobURL(path.Join(d.prefix, key))
+	_, err = azblob.UploadFileToBlockBlob(ctx, f, blobURL, azblob.UploadToBlockBlobOptions{
+		BlockSize:   4 * 1024 * 1024,
+		Parallelism: 4,
+		Metadata:    metadata,
+	})
+
+	return err
+}
+
+func (d *azureDestination) Remove(ctx context.Context, key string) error {
+	blobURL := d.containerURL.NewBlockBlobURL(path.Join(d.prefix, key))
+	_, err := blobURL.Delete(ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+
+	return err
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIsBlobHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"s3://bucket/prefix", true},
+		{"gs://bucket/prefix", true},
+		{"az://container/prefix", true},
+		{"example.com:22", false},
+		{"10.0.0.1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isBlobHost(tt.host); got != tt.want {
+			t.Errorf("isBlobHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestDestinationKey(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"plain", Config{}, "archive.tar.gz"},
+		{"encrypted", Config{Encrypt: true}, "archive.tar.gz.age"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := destinationKey(tt.cfg); got != tt.want {
+				t.Errorf("destinationKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDestination records the keys sendToDest removed and sent to, so the
+// remove-before-send behaviour can be verified without dialing a real
+// cloud provider.
+type fakeDestination struct {
+	removed   []string
+	sent      []string
+	removeErr error
+}
+
+func (d *fakeDestination) Send(ctx context.Context, localTar, key string) error {
+	d.sent = append(d.sent, key)
+	return nil
+}
+
+func (d *fakeDestination) Remove(ctx context.Context, key string) error {
+	d.removed = append(d.removed, key)
+	return d.removeErr
+}
+
+func TestSendToDestUsesStableKey(t *testing.T) {
+	p := &Plugin{Config: Config{Remove: true}}
+	dest := &fakeDestination{}
+
+	if err := p.sendToDest(context.Background(), dest, "s3://bucket/prefix", "/tmp/abc123.tar.gz"); err != nil {
+		t.Fatalf("sendToDest() error = %v", err)
+	}
+
+	if len(dest.removed) != 1 || dest.removed[0] != "archive.tar.gz" {
+		t.Fatalf("sendToDest() removed = %v, want [\"archive.tar.gz\"]", dest.removed)
+	}
+
+	if len(dest.sent) != 1 || dest.sent[0] != "archive.tar.gz" {
+		t.Fatalf("sendToDest() sent = %v, want [\"archive.tar.gz\"]", dest.sent)
+	}
+
+	if dest.removed[0] != dest.sent[0] {
+		t.Fatalf("Remove key %q and Send key %q must match, or Remove can never find what a previous run uploaded", dest.removed[0], dest.sent[0])
+	}
+}
+
+func TestSendToDestSkipsRemoveWhenNotConfigured(t *testing.T) {
+	p := &Plugin{Config: Config{}}
+	dest := &fakeDestination{}
+
+	if err := p.sendToDest(context.Background(), dest, "s3://bucket/prefix", "/tmp/abc123.tar.gz"); err != nil {
+		t.Fatalf("sendToDest() error = %v", err)
+	}
+
+	if len(dest.removed) != 0 {
+		t.Fatalf("sendToDest() removed = %v, want none when Config.Remove is false", dest.removed)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// encryptArchive encrypts the tar archive at tarPath for recipients (age
+// X25519 public keys or ssh-ed25519/ssh-rsa keys), writing tarPath+".age"
+// alongside it. The plaintext archive is left in place; callers that scp
+// only the encrypted file are responsible for cleaning it up.
+func encryptArchive(tarPath string, recipients []string) (string, error) {
+	ageRecipients, err := parseRecipients(recipients)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(tarPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	encPath := tarPath + ".age"
+
+	dst, err := os.Create(encPath)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	w, err := age.Encrypt(dst, ageRecipients...)
+	if err != nil {
+		return "", fmt.Errorf("age encrypt: %w", err)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		return "", err
+	}
+
+	return encPath, w.Close()
+}
+
+// parseRecipients accepts the age X25519 and ssh-ed25519/ssh-rsa public key
+// formats Config.EncryptRecipients is documented to take.
+func parseRecipients(raw []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+
+	for _, r := range raw {
+		if strings.HasPrefix(r, "ssh-") {
+			recipient, err := agessh.ParseRecipient(r)
+			if err != nil {
+				return nil, fmt.Errorf("parse ssh recipient %q: %w", r, err)
+			}
+
+			recipients = append(recipients, recipient)
+			continue
+		}
+
+		recipient, err := age.ParseX25519Recipient(r)
+		if err != nil {
+			return nil, fmt.Errorf("parse age recipient %q: %w", r, err)
+		}
+
+		recipients = append(recipients, recipient)
+	}
+
+	return recipients, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestParseRecipients(t *testing.T) {
+	const (
+		x25519Recipient = "age139rtzsr3alwyvw5ufrs4nz0m67pt7dcd43hu5d6yu23nktle3ads69rtyj"
+		sshRecipient    = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICjo1euow00W0B/WqkO9TFe96JPQHWq6ESDdDN3n5Gic"
+	)
+
+	t.Run("age and ssh recipients", func(t *testing.T) {
+		recipients, err := parseRecipients([]string{x25519Recipient, sshRecipient})
+		if err != nil {
+			t.Fatalf("parseRecipients() error = %v", err)
+		}
+		if len(recipients) != 2 {
+			t.Fatalf("parseRecipients() returned %d recipients, want 2", len(recipients))
+		}
+	})
+
+	t.Run("invalid age recipient", func(t *testing.T) {
+		if _, err := parseRecipients([]string{"not-a-valid-recipient"}); err == nil {
+			t.Fatal("parseRecipients() expected an error for an invalid recipient, got nil")
+		}
+	})
+
+	t.Run("invalid ssh recipient", func(t *testing.T) {
+		if _, err := parseRecipients([]string{"ssh-ed25519 not-valid-base64!!"}); err == nil {
+			t.Fatal("parseRecipients() expected an error for an invalid ssh recipient, got nil")
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		recipients, err := parseRecipients(nil)
+		if err != nil {
+			t.Fatalf("parseRecipients() error = %v", err)
+		}
+		if len(recipients) != 0 {
+			t.Fatalf("parseRecipients() returned %d recipients, want 0", len(recipients))
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/zeebo/blake3"
+)
+
+// manifestName is where each target's manifest from the previous
+// incremental run is stored, so this run can diff against it without a
+// remote helper process.
+const manifestName = ".drone-scp-manifest.json"
+
+// manifestEntry is one file's content-addressed record, keyed by its
+// path relative to the target (after StripComponents is applied). It is
+// both the shape persisted to manifestName and the shape diffed against
+// the freshly computed local manifest.
+type manifestEntry struct {
+	RelPath string    `json:"relative_path"`
+	Size    int64     `json:"size"`
+	Mtime   time.Time `json:"mtime"`
+	Hash    string    `json:"blake3_hash"`
+}
+
+// buildManifest hashes every file matched by files.Source and records it
+// under its target-relative path, alongside the local path it came from
+// so a changed entry can be mapped back to a source for tar/sftp/rsync.
+// Directory entries are walked recursively, the same as sftpTransporter's
+// uploadPath, so directory-based Config.Source entries are hashed file by
+// file rather than skipped.
+func buildManifest(files fileList, strip int) ([]manifestEntry, map[string]string, error) {
+	var manifest []manifestEntry
+	bySrc := make(map[string]string)
+
+	add := func(src string, info os.FileInfo) error {
+		hash, err := hashFile(src)
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", src, err)
+		}
+
+		relPath := stripComponents(src, strip)
+		manifest = append(manifest, manifestEntry{
+			RelPath: relPath,
+			Size:    info.Size(),
+			Mtime:   info.ModTime().UTC(),
+			Hash:    hash,
+		})
+		bySrc[relPath] = src
+
+		return nil
+	}
+
+	for _, src := range files.Source {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !info.IsDir() {
+			if err := add(src, info); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		err = filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || fi.IsDir() {
+				return err
+			}
+
+			return add(p, fi)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return manifest, bySrc, nil
+}
+
+// hashFile returns the hex-encoded blake3 digest of path's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := blake3.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetchManifest reads the manifest left by a previous incremental run of
+// target on host, if any. A missing manifest is not an error: it just
+// means every file is treated as changed.
+func fetchManifest(ssh remoteSession, target string, timeout time.Duration) (map[string]manifestEntry, error) {
+	outStr, _, _, err := ssh.Run(fmt.Sprintf("cat %s", path.Join(target, manifestName)), timeout)
+	if err != nil || outStr == "" {
+		return nil, nil
+	}
+
+	var entries []manifestEntry
+	if err := json.Unmarshal([]byte(outStr), &entries); err != nil {
+		return nil, nil
+	}
+
+	prev := make(map[string]manifestEntry, len(entries))
+	for _, e := range entries {
+		prev[e.RelPath] = e
+	}
+
+	return prev, nil
+}
+
+// incrementalPlan diffs the local manifest for files against the
+// previous one stored on host/target, returning the narrowed fileList to
+// ship, the relative paths that disappeared locally (candidates for
+// removal), and the manifest to persist once the upload succeeds.
+func incrementalPlan(ssh remoteSession, target string, cfg Config, files fileList) (fileList, []string, []manifestEntry, error) {
+	local, bySrc, err := buildManifest(files, cfg.StripComponents)
+	if err != nil {
+		return fileList{}, nil, nil, err
+	}
+
+	prev, err := fetchManifest(ssh, target, cfg.CommandTimeout)
+	if err != nil {
+		return fileList{}, nil, nil, err
+	}
+
+	changed := fileList{Ignore: files.Ignore}
+	seen := make(map[string]bool, len(local))
+	for _, entry := range local {
+		seen[entry.RelPath] = true
+
+		if old, ok := prev[entry.RelPath]; ok && old.Hash == entry.Hash {
+			continue
+		}
+
+		changed.Source = append(changed.Source, bySrc[entry.RelPath])
+	}
+
+	var removed []string
+	for relPath := range prev {
+		if !seen[relPath] {
+			removed = append(removed, relPath)
+		}
+	}
+
+	return changed, removed, local, nil
+}
+
+// writeManifest persists manifest to target/manifestName, via a
+// write-then-rename so a run that dies mid-upload never leaves a
+// truncated manifest for the next one to diff against.
+func writeManifest(ssh remoteSession, target string, manifest []manifestEntry, timeout time.Duration) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	dest := path.Join(target, manifestName)
+	command := fmt.Sprintf("cat > %s.tmp <<'DRONE_SCP_MANIFEST_EOF'\n%s\nDRONE_SCP_MANIFEST_EOF\nmv %s.tmp %s",
+		dest, data, dest, dest)
+
+	_, errStr, _, err := ssh.Run(command, timeout)
+	if err != nil {
+		return err
+	}
+
+	if errStr != "" {
+		return fmt.Errorf("write manifest: %s", errStr)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestBuildManifestWalksDirectories(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, bySrc, err := buildManifest(fileList{Source: []string{dir}}, 0)
+	if err != nil {
+		t.Fatalf("buildManifest() error = %v", err)
+	}
+
+	if len(manifest) != 2 {
+		t.Fatalf("buildManifest() returned %d entries, want 2 (got %+v)", len(manifest), manifest)
+	}
+
+	var sawA, sawB bool
+	for _, entry := range manifest {
+		switch {
+		case strings.HasSuffix(entry.RelPath, "a.txt"):
+			sawA = true
+		case strings.HasSuffix(entry.RelPath, filepath.ToSlash(filepath.Join("sub", "b.txt"))):
+			sawB = true
+		}
+
+		if bySrc[entry.RelPath] == "" {
+			t.Errorf("bySrc missing source path for %q", entry.RelPath)
+		}
+	}
+
+	if !sawA || !sawB {
+		t.Fatalf("expected manifest to contain both nested files, got %+v", manifest)
+	}
+}
+
+// fakeManifestSession is a minimal remoteSession that only answers the
+// "cat <manifest>" command incrementalPlan issues via fetchManifest.
+type fakeManifestSession struct {
+	manifestJSON string
+}
+
+func (f *fakeManifestSession) Run(command string, timeout time.Duration) (string, string, bool, error) {
+	if strings.HasPrefix(command, "cat ") {
+		return f.manifestJSON, "", false, nil
+	}
+
+	return "", "", false, nil
+}
+
+func (f *fakeManifestSession) Scp(sourceFile, targetFile string) error { return nil }
+
+func (f *fakeManifestSession) Client() (*ssh.Client, error) { return nil, nil }
+
+func (f *fakeManifestSession) Close() {}
+
+func TestIncrementalPlan(t *testing.T) {
+	dir := t.TempDir()
+	unchanged := filepath.Join(dir, "unchanged.txt")
+	changed := filepath.Join(dir, "changed.txt")
+
+	if err := os.WriteFile(unchanged, []byte("same"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(changed, []byte("new content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := fileList{Source: []string{unchanged, changed}}
+
+	// compute what the previous run's manifest would have stored: the
+	// current hash for unchanged.txt, and a stale hash for changed.txt.
+	local, _, err := buildManifest(files, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prevJSON strings.Builder
+	prevJSON.WriteString("[")
+	for i, entry := range local {
+		if i > 0 {
+			prevJSON.WriteString(",")
+		}
+		hash := entry.Hash
+		if filepath.Base(entry.RelPath) == "changed.txt" {
+			hash = "stale"
+		}
+		prevJSON.WriteString(`{"relative_path":"` + entry.RelPath + `","size":0,"mtime":"2020-01-01T00:00:00Z","blake3_hash":"` + hash + `"}`)
+	}
+	prevJSON.WriteString("]")
+
+	ssh := &fakeManifestSession{manifestJSON: prevJSON.String()}
+
+	narrowed, removed, _, err := incrementalPlan(ssh, "/srv/target", Config{}, files)
+	if err != nil {
+		t.Fatalf("incrementalPlan() error = %v", err)
+	}
+
+	if len(removed) != 0 {
+		t.Fatalf("incrementalPlan() removed = %v, want none", removed)
+	}
+
+	if len(narrowed.Source) != 1 || narrowed.Source[0] != changed {
+		t.Fatalf("incrementalPlan() narrowed.Source = %v, want only %q", narrowed.Source, changed)
+	}
+}
+
+func TestIncrementalPlanNoPreviousManifest(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(src, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := fileList{Source: []string{src}}
+	ssh := &fakeManifestSession{manifestJSON: ""}
+
+	narrowed, removed, manifest, err := incrementalPlan(ssh, "/srv/target", Config{}, files)
+	if err != nil {
+		t.Fatalf("incrementalPlan() error = %v", err)
+	}
+
+	if len(narrowed.Source) != 1 {
+		t.Fatalf("incrementalPlan() with no previous manifest should ship everything, got %v", narrowed.Source)
+	}
+
+	if len(removed) != 0 {
+		t.Fatalf("incrementalPlan() removed = %v, want none", removed)
+	}
+
+	if len(manifest) != 1 {
+		t.Fatalf("incrementalPlan() manifest = %v, want 1 entry", manifest)
+	}
+}
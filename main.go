@@ -0,0 +1,311 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/joho/godotenv"
+	"github.com/urfave/cli/v2"
+)
+
+// Version set at compile-time.
+var (
+	Version string
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	app := cli.NewApp()
+	app.Name = "scp plugin"
+	app.Usage = "copy files and artifacts via ssh"
+	app.Copyright = "Copyright (c) " + time.Now().Format("2006") + " appleboy"
+	app.Action = run
+	app.Version = Version
+	app.Flags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "host",
+			Usage:   "connect to the host",
+			EnvVars: []string{"PLUGIN_HOST", "SCP_HOST", "SSH_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "port",
+			Usage:   "connect to the port",
+			Value:   "22",
+			EnvVars: []string{"PLUGIN_PORT", "SCP_PORT", "SSH_PORT"},
+		},
+		&cli.StringFlag{
+			Name:    "username",
+			Usage:   "connect as user",
+			EnvVars: []string{"PLUGIN_USERNAME", "SCP_USERNAME", "SSH_USERNAME"},
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Usage:   "user password",
+			EnvVars: []string{"PLUGIN_PASSWORD", "SCP_PASSWORD", "SSH_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:    "key",
+			Usage:   "private ssh key",
+			EnvVars: []string{"PLUGIN_KEY", "SCP_KEY", "SSH_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "key-path",
+			Usage:   "private ssh key path",
+			EnvVars: []string{"PLUGIN_KEY_PATH", "SCP_KEY_PATH", "SSH_KEY_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "passphrase",
+			Usage:   "password for a ssh key",
+			EnvVars: []string{"PLUGIN_PASSPHRASE", "SCP_PASSPHRASE", "SSH_PASSPHRASE"},
+		},
+		&cli.StringFlag{
+			Name:    "fingerprint",
+			Usage:   "fingerprint SHA256 verify public key of the host",
+			EnvVars: []string{"PLUGIN_FINGERPRINT", "SCP_FINGERPRINT", "SSH_FINGERPRINT"},
+		},
+		&cli.DurationFlag{
+			Name:    "timeout",
+			Usage:   "connection timeout",
+			Value:   30 * time.Second,
+			EnvVars: []string{"PLUGIN_TIMEOUT", "SCP_TIMEOUT", "SSH_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "command-timeout",
+			Usage:   "command timeout",
+			Value:   10 * time.Minute,
+			EnvVars: []string{"PLUGIN_COMMAND_TIMEOUT", "SCP_COMMAND_TIMEOUT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "target",
+			Usage:   "target path on remote host",
+			EnvVars: []string{"PLUGIN_TARGET"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "source",
+			Usage:   "source path of copy files",
+			EnvVars: []string{"PLUGIN_SOURCE"},
+		},
+		&cli.BoolFlag{
+			Name:    "rm",
+			Usage:   "remove target folder before copy data",
+			EnvVars: []string{"PLUGIN_RM"},
+		},
+		&cli.IntFlag{
+			Name:    "strip-components",
+			Usage:   "strip number of leading components from file names on extraction",
+			EnvVars: []string{"PLUGIN_STRIP_COMPONENTS"},
+		},
+		&cli.StringFlag{
+			Name:    "tar-exec",
+			Usage:   "tar command execution",
+			Value:   "tar",
+			EnvVars: []string{"PLUGIN_TAR_EXEC"},
+		},
+		&cli.StringFlag{
+			Name:    "tar-tmp-path",
+			Usage:   "tar tmp path for remote host",
+			Value:   "",
+			EnvVars: []string{"PLUGIN_TAR_TMP_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "transport",
+			Usage:   "how to ship files to the host: tar, sftp or rsync",
+			Value:   "tar",
+			EnvVars: []string{"PLUGIN_TRANSPORT"},
+		},
+		&cli.BoolFlag{
+			Name:    "streaming",
+			Usage:   "stream the tar archive over a single multiplexed ssh channel instead of scp-ing a temp file (tar transport only)",
+			EnvVars: []string{"PLUGIN_STREAMING"},
+		},
+		&cli.StringFlag{
+			Name:    "sse",
+			Usage:   "server-side encryption mode for object storage destinations (e.g. aws:kms)",
+			EnvVars: []string{"PLUGIN_SSE"},
+		},
+		&cli.StringFlag{
+			Name:    "kms-key-id",
+			Usage:   "KMS key id used with --sse for object storage destinations",
+			EnvVars: []string{"PLUGIN_KMS_KEY_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "retention-tag",
+			Usage:   "tag/metadata value applied to uploads to object storage destinations, for consumption by a bucket lifecycle policy",
+			EnvVars: []string{"PLUGIN_RETENTION_TAG"},
+		},
+		&cli.BoolFlag{
+			Name:    "encrypt",
+			Usage:   "encrypt the tar archive with age before copying it, so plaintext never touches remote disk (tar transport only)",
+			EnvVars: []string{"PLUGIN_ENCRYPT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "encrypt-recipients",
+			Usage:   "age X25519 or ssh-ed25519/ssh-rsa public keys to encrypt the archive for",
+			EnvVars: []string{"PLUGIN_ENCRYPT_RECIPIENTS"},
+		},
+		&cli.StringFlag{
+			Name:    "encrypt-identity-file",
+			Usage:   "local path to the age identity matching --encrypt-recipients, for pipelines that need to decrypt the archive themselves",
+			EnvVars: []string{"PLUGIN_ENCRYPT_IDENTITY_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "remote-identity-path",
+			Usage:   "path to the age identity file on the target host, used to decrypt the archive before untarring",
+			EnvVars: []string{"PLUGIN_REMOTE_IDENTITY_PATH"},
+		},
+		&cli.BoolFlag{
+			Name:    "incremental",
+			Usage:   "only ship files whose content has changed since the last run, diffed against a manifest left on the target (tar transport only)",
+			EnvVars: []string{"PLUGIN_INCREMENTAL"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-host",
+			Usage:   "proxy host",
+			EnvVars: []string{"PLUGIN_PROXY_HOST", "SCP_PROXY_HOST", "SSH_PROXY_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-port",
+			Usage:   "proxy port",
+			Value:   "22",
+			EnvVars: []string{"PLUGIN_PROXY_PORT", "SCP_PROXY_PORT", "SSH_PROXY_PORT"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-username",
+			Usage:   "proxy username",
+			EnvVars: []string{"PLUGIN_PROXY_USERNAME", "SCP_PROXY_USERNAME", "SSH_PROXY_USERNAME"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-password",
+			Usage:   "proxy password",
+			EnvVars: []string{"PLUGIN_PROXY_PASSWORD", "SCP_PROXY_PASSWORD", "SSH_PROXY_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-key",
+			Usage:   "proxy private ssh key",
+			EnvVars: []string{"PLUGIN_PROXY_KEY", "SCP_PROXY_KEY", "SSH_PROXY_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-key-path",
+			Usage:   "proxy private ssh key path",
+			EnvVars: []string{"PLUGIN_PROXY_KEY_PATH", "SCP_PROXY_KEY_PATH", "SSH_PROXY_KEY_PATH"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-passphrase",
+			Usage:   "password for a proxy ssh key",
+			EnvVars: []string{"PLUGIN_PROXY_PASSPHRASE", "SCP_PROXY_PASSPHRASE", "SSH_PROXY_PASSPHRASE"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-type",
+			Usage:   "how to reach the host: ssh, socks5 or http; defaults to ssh (proxy-host) or the HTTPS_PROXY/ALL_PROXY environment",
+			EnvVars: []string{"PLUGIN_PROXY_TYPE"},
+		},
+		&cli.StringFlag{
+			Name:    "proxy-url",
+			Usage:   "socks5:// or http:// proxy address used when proxy-type is socks5 or http",
+			EnvVars: []string{"PLUGIN_PROXY_URL"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "no-proxy",
+			Usage:   "hosts that should always be dialed directly, bypassing proxy-type/proxy-url and NO_PROXY",
+			EnvVars: []string{"PLUGIN_NO_PROXY", "NO_PROXY"},
+		},
+		&cli.BoolFlag{
+			Name:    "debug",
+			Usage:   "enable debug mode",
+			EnvVars: []string{"PLUGIN_DEBUG", "SCP_DEBUG", "SSH_DEBUG"},
+		},
+		&cli.BoolFlag{
+			Name:    "overwrite",
+			Usage:   "overwrite existing files on remote host",
+			EnvVars: []string{"PLUGIN_OVERWRITE"},
+		},
+		&cli.BoolFlag{
+			Name:    "unlink-first",
+			Usage:   "remove existing files on remote host before untar",
+			EnvVars: []string{"PLUGIN_UNLINK_FIRST"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "ciphers",
+			Usage:   "ciphers allowed to be used for the SSH connection",
+			EnvVars: []string{"PLUGIN_CIPHERS"},
+		},
+		&cli.BoolFlag{
+			Name:    "insecure-cipher",
+			Usage:   "include more ciphers with insecure algorithm",
+			EnvVars: []string{"PLUGIN_INSECURE_CIPHER"},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(c *cli.Context) error {
+	plugin := Plugin{
+		Repo: Repo{
+			Owner: c.String("repo.owner"),
+			Name:  c.String("repo.name"),
+		},
+		Build: Build{
+			Event:   c.String("build.event"),
+			Number:  c.Int("build.number"),
+			Commit:  c.String("commit.sha"),
+			Message: c.String("commit.message"),
+			Branch:  c.String("commit.branch"),
+			Author:  c.String("commit.author"),
+			Status:  c.String("build.status"),
+			Link:    c.String("build.link"),
+		},
+		Config: Config{
+			Host:                c.StringSlice("host"),
+			Port:                c.String("port"),
+			Username:            c.String("username"),
+			Password:            c.String("password"),
+			Key:                 c.String("key"),
+			KeyPath:             c.String("key-path"),
+			Passphrase:          c.String("passphrase"),
+			Fingerprint:         c.String("fingerprint"),
+			Timeout:             c.Duration("timeout"),
+			CommandTimeout:      c.Duration("command-timeout"),
+			Target:              c.StringSlice("target"),
+			Source:              c.StringSlice("source"),
+			Remove:              c.Bool("rm"),
+			StripComponents:     c.Int("strip-components"),
+			TarExec:             c.String("tar-exec"),
+			TarTmpPath:          c.String("tar-tmp-path"),
+			Transport:           c.String("transport"),
+			Streaming:           c.Bool("streaming"),
+			SSE:                 c.String("sse"),
+			KMSKeyID:            c.String("kms-key-id"),
+			RetentionTag:        c.String("retention-tag"),
+			Encrypt:             c.Bool("encrypt"),
+			EncryptRecipients:   c.StringSlice("encrypt-recipients"),
+			EncryptIdentityFile: c.String("encrypt-identity-file"),
+			RemoteIdentityPath:  c.String("remote-identity-path"),
+			Incremental:         c.Bool("incremental"),
+			Debug:               c.Bool("debug"),
+			Overwrite:           c.Bool("overwrite"),
+			UnlinkFirst:         c.Bool("unlink-first"),
+			Ciphers:             c.StringSlice("ciphers"),
+			UseInsecureCipher:   c.Bool("insecure-cipher"),
+			ProxyType:           c.String("proxy-type"),
+			ProxyURL:            c.String("proxy-url"),
+			NoProxy:             c.StringSlice("no-proxy"),
+			Proxy: easyssh.DefaultConfig{
+				Server:     c.String("proxy-host"),
+				Port:       c.String("proxy-port"),
+				User:       c.String("proxy-username"),
+				Password:   c.String("proxy-password"),
+				Key:        c.String("proxy-key"),
+				KeyPath:    c.String("proxy-key-path"),
+				Passphrase: c.String("proxy-passphrase"),
+			},
+		},
+	}
+
+	return plugin.Exec()
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ScaleFT/sshkeys"
+	"github.com/appleboy/easyssh-proxy"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// Supported values for Config.ProxyType.
+const (
+	proxyTypeSSH    = "ssh"
+	proxyTypeSOCKS5 = "socks5"
+	proxyTypeHTTP   = "http"
+)
+
+// remoteSession is the subset of easyssh.MakeConfig's behaviour the plugin
+// needs to reach a host, so a socks5/http tunnelled *ssh.Client can stand in
+// for the bastion-or-direct connection easyssh dials on its own.
+type remoteSession interface {
+	Run(command string, timeout time.Duration) (outStr, errStr string, isTimeout bool, err error)
+	Scp(sourceFile, targetFile string) error
+	// Client returns the underlying *ssh.Client, dialing one if the
+	// session (like easysshSession) only connects on demand. Backends
+	// that need more than Run/Scp, such as the sftp transport, use this.
+	Client() (*ssh.Client, error)
+	Close()
+}
+
+// easysshSession is the default path: no proxy, or an SSH bastion, both of
+// which easyssh already knows how to dial.
+type easysshSession struct {
+	ssh *easyssh.MakeConfig
+}
+
+func (s easysshSession) Run(command string, timeout time.Duration) (string, string, bool, error) {
+	return s.ssh.Run(command, timeout)
+}
+
+func (s easysshSession) Scp(sourceFile, targetFile string) error {
+	return s.ssh.Scp(sourceFile, targetFile)
+}
+
+func (s easysshSession) Client() (*ssh.Client, error) {
+	session, client, err := s.ssh.Connect()
+	if err != nil {
+		return nil, err
+	}
+	session.Close()
+
+	return client, nil
+}
+
+func (s easysshSession) Close() {}
+
+// newSSHConfig builds the easyssh.MakeConfig used to reach host, carrying
+// over the bastion proxy settings unchanged.
+func (p Plugin) newSSHConfig(host string) *easyssh.MakeConfig {
+	return &easyssh.MakeConfig{
+		Server:            host,
+		User:              p.Config.Username,
+		Password:          p.Config.Password,
+		Port:              p.Config.Port,
+		Key:               p.Config.Key,
+		KeyPath:           p.Config.KeyPath,
+		Passphrase:        p.Config.Passphrase,
+		Timeout:           p.Config.Timeout,
+		Ciphers:           p.Config.Ciphers,
+		Fingerprint:       p.Config.Fingerprint,
+		UseInsecureCipher: p.Config.UseInsecureCipher,
+		Proxy: easyssh.DefaultConfig{
+			Server:            p.Config.Proxy.Server,
+			User:              p.Config.Proxy.User,
+			Password:          p.Config.Proxy.Password,
+			Port:              p.Config.Proxy.Port,
+			Key:               p.Config.Proxy.Key,
+			KeyPath:           p.Config.Proxy.KeyPath,
+			Passphrase:        p.Config.Proxy.Passphrase,
+			Timeout:           p.Config.Proxy.Timeout,
+			Ciphers:           p.Config.Proxy.Ciphers,
+			Fingerprint:       p.Config.Proxy.Fingerprint,
+			UseInsecureCipher: p.Config.Proxy.UseInsecureCipher,
+		},
+	}
+}
+
+// newRemoteSession picks the transport used to reach host: a socks5/http
+// tunnel when one is configured (or discoverable from the environment) for
+// this host, otherwise the existing easyssh bastion-or-direct dial.
+func (p Plugin) newRemoteSession(host string) (remoteSession, error) {
+	proxyType, proxyURL := resolveProxy(p.Config, host)
+	if proxyType != proxyTypeSOCKS5 && proxyType != proxyTypeHTTP {
+		return easysshSession{ssh: p.newSSHConfig(host)}, nil
+	}
+
+	addr := net.JoinHostPort(host, p.Config.Port)
+
+	conn, err := dialProxy(proxyType, proxyURL, addr, p.Config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s proxy for %s: %w", proxyType, host, err)
+	}
+
+	clientConfig, err := sshClientConfig(p.Config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return proxySession{client: ssh.NewClient(ncc, chans, reqs)}, nil
+}
+
+// resolveProxy works out which proxy, if any, should be used to reach host.
+// Config.ProxyType/ProxyURL take precedence; Config.Proxy (the SSH bastion)
+// is reported as "ssh" for callers that only care whether a tunnel is
+// needed; otherwise the usual HTTPS_PROXY/ALL_PROXY/NO_PROXY environment
+// variables are consulted, mirroring what git does for its transports.
+func resolveProxy(cfg Config, host string) (proxyType, rawURL string) {
+	if noProxyMatch(host, cfg.NoProxy) {
+		return "", ""
+	}
+
+	if cfg.ProxyType != "" {
+		return cfg.ProxyType, cfg.ProxyURL
+	}
+
+	if cfg.Proxy.Server != "" {
+		return proxyTypeSSH, ""
+	}
+
+	envProxy := os.Getenv("ALL_PROXY")
+	if envProxy == "" {
+		envProxy = os.Getenv("HTTPS_PROXY")
+	}
+	if envProxy == "" {
+		envProxy = os.Getenv("https_proxy")
+	}
+	if envProxy == "" {
+		return "", ""
+	}
+
+	if noProxyMatch(host, trimPath(strings.Split(os.Getenv("NO_PROXY"), ","))) {
+		return "", ""
+	}
+
+	u, err := url.Parse(envProxy)
+	if err != nil {
+		return "", ""
+	}
+
+	if strings.HasPrefix(u.Scheme, "socks5") {
+		return proxyTypeSOCKS5, envProxy
+	}
+
+	return proxyTypeHTTP, envProxy
+}
+
+// noProxyMatch reports whether host is covered by one of the NO_PROXY style
+// patterns: an exact match, a suffix match on ".example.com", or "*".
+func noProxyMatch(host string, noProxy []string) bool {
+	for _, skip := range noProxy {
+		skip = strings.TrimSpace(skip)
+		switch {
+		case skip == "":
+			continue
+		case skip == "*":
+			return true
+		case host == skip:
+			return true
+		case strings.HasSuffix(host, "."+strings.TrimPrefix(skip, ".")):
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialProxy establishes the TCP connection to addr through a socks5 or HTTP
+// CONNECT proxy so the resulting net.Conn can be handed to ssh.NewClientConn.
+func dialProxy(proxyType, rawURL, addr string, timeout time.Duration) (net.Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %q: %w", rawURL, err)
+	}
+
+	switch proxyType {
+	case proxyTypeSOCKS5:
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+
+		dialer, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: timeout})
+		if err != nil {
+			return nil, err
+		}
+
+		return dialer.Dial("tcp", addr)
+	case proxyTypeHTTP:
+		return dialHTTPConnect(u, addr, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy type %q", proxyType)
+	}
+}
+
+// dialHTTPConnect opens a plain TCP connection to the proxy and asks it, via
+// the HTTP CONNECT method, to tunnel bytes through to addr.
+func dialHTTPConnect(proxyURL *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		req.SetBasicAuth(proxyURL.User.Username(), password)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+
+	return conn, nil
+}
+
+// sshClientConfig builds the ssh.ClientConfig used once the TCP connection
+// has already been dialled through a socks5/http proxy, mirroring the auth
+// handling easyssh applies for the direct-dial case.
+func sshClientConfig(cfg Config) (*ssh.ClientConfig, error) {
+	var auths []ssh.AuthMethod
+
+	if cfg.Password != "" {
+		auths = append(auths, ssh.Password(cfg.Password))
+	}
+
+	if cfg.KeyPath != "" {
+		buf, err := os.ReadFile(cfg.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, err := parsePrivateKey(buf, cfg.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if cfg.Key != "" {
+		signer, err := parsePrivateKey([]byte(cfg.Key), cfg.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cfg.Fingerprint != "" {
+		hostKeyCallback = func(hostname string, remote net.Addr, publicKey ssh.PublicKey) error {
+			if ssh.FingerprintSHA256(publicKey) != cfg.Fingerprint {
+				return fmt.Errorf("ssh: host key fingerprint mismatch")
+			}
+			return nil
+		}
+	}
+
+	return &ssh.ClientConfig{
+		Timeout:         cfg.Timeout,
+		User:            cfg.Username,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func parsePrivateKey(pemBytes []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return sshkeys.ParseEncryptedPrivateKey(pemBytes, []byte(passphrase))
+	}
+
+	return ssh.ParsePrivateKey(pemBytes)
+}
+
+// proxySession runs commands and copies files over an *ssh.Client dialled
+// through a socks5/http tunnel, replicating the bits of easyssh.MakeConfig
+// the plugin relies on.
+type proxySession struct {
+	client *ssh.Client
+}
+
+func (s proxySession) Run(command string, timeout time.Duration) (string, string, bool, error) {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return "", "", false, err
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+
+	select {
+	case err := <-done:
+		return stdout.String(), stderr.String(), false, err
+	case <-time.After(timeout):
+		return "", "Run Command Timeout!", true, nil
+	}
+}
+
+func (s proxySession) Scp(sourceFile, targetFile string) error {
+	session, err := s.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	src, err := os.Open(sourceFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	srcStat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	copyErrC := make(chan error, 1)
+	go func() {
+		defer w.Close()
+
+		if _, err := fmt.Fprintln(w, "C0644", srcStat.Size(), filepath.Base(targetFile)); err != nil {
+			copyErrC <- err
+			return
+		}
+
+		if srcStat.Size() > 0 {
+			if _, err := bufio.NewReader(src).WriteTo(w); err != nil {
+				copyErrC <- err
+				return
+			}
+		}
+
+		_, err := fmt.Fprint(w, "\x00")
+		copyErrC <- err
+	}()
+
+	if err := session.Run(fmt.Sprintf("scp -tr %s", targetFile)); err != nil {
+		return err
+	}
+
+	return <-copyErrC
+}
+
+func (s proxySession) Client() (*ssh.Client, error) {
+	return s.client, nil
+}
+
+func (s proxySession) Close() {
+	s.client.Close()
+}
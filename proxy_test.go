@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appleboy/easyssh-proxy"
+)
+
+func TestNoProxyMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		noProxy []string
+		want    bool
+	}{
+		{"empty list", "example.com", nil, false},
+		{"exact match", "example.com", []string{"example.com"}, true},
+		{"no match", "example.com", []string{"other.com"}, false},
+		{"suffix match", "host.example.com", []string{".example.com"}, true},
+		{"suffix match without leading dot", "host.example.com", []string{"example.com"}, true},
+		{"suffix does not match bare domain", "example.com", []string{".example.com"}, false},
+		{"wildcard", "anything:22", []string{"*"}, true},
+		{"blank entries are skipped", "example.com", []string{"", "  "}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := noProxyMatch(tt.host, tt.noProxy); got != tt.want {
+				t.Errorf("noProxyMatch(%q, %v) = %v, want %v", tt.host, tt.noProxy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		host     string
+		wantType string
+	}{
+		{
+			name:     "no config, no env",
+			cfg:      Config{},
+			host:     "example.com:22",
+			wantType: "",
+		},
+		{
+			name:     "explicit proxy type wins",
+			cfg:      Config{ProxyType: proxyTypeSOCKS5, ProxyURL: "socks5://localhost:1080"},
+			host:     "example.com:22",
+			wantType: proxyTypeSOCKS5,
+		},
+		{
+			name:     "no-proxy overrides explicit type",
+			cfg:      Config{ProxyType: proxyTypeSOCKS5, ProxyURL: "socks5://localhost:1080", NoProxy: []string{"example.com:22"}},
+			host:     "example.com:22",
+			wantType: "",
+		},
+		{
+			name:     "ssh bastion reported as ssh",
+			cfg:      Config{Proxy: easyssh.DefaultConfig{Server: "bastion.example.com"}},
+			host:     "example.com:22",
+			wantType: proxyTypeSSH,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotType, _ := resolveProxy(tt.cfg, tt.host)
+			if gotType != tt.wantType {
+				t.Errorf("resolveProxy() type = %q, want %q", gotType, tt.wantType)
+			}
+		})
+	}
+}
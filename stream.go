@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// streamTransporter pipes a freshly built tar stream straight into a single
+// SSH session instead of writing a temporary archive and scp-ing it: it
+// starts the same "tar -xzf - -C target" command buildArgs would run for a
+// scp'd file, then streams the local tar command's stdout directly into
+// that session's stdin over the one SSH connection. See Config.Streaming.
+type streamTransporter struct {
+	host   string
+	ssh    remoteSession
+	plugin *Plugin
+}
+
+func (t *streamTransporter) Upload(ctx context.Context, files fileList, target string) error {
+	client, err := t.ssh.Client()
+	if err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("open streaming session to %s: %w", t.host, err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	p := t.plugin
+
+	// the remote tar always reads the stream from stdin, regardless of
+	// Config.Encrypt: there is no temp file on the remote side to name.
+	command := strings.Join(p.buildArgsFor("-", target), " ")
+	if p.Config.Debug {
+		fmt.Println("$", command)
+	}
+
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("start remote tar on %s: %w", t.host, err)
+	}
+
+	args := buildArgs("-", files)
+	cmd := exec.CommandContext(ctx, p.Config.TarExec, args...)
+	cmd.Stdout = stdin
+	if p.Config.Debug {
+		fmt.Println("$", p.Config.TarExec, args)
+	}
+
+	if err := cmd.Run(); err != nil {
+		stdin.Close()
+		return fmt.Errorf("stream tar to %s: %w", t.host, err)
+	}
+
+	if err := stdin.Close(); err != nil {
+		return err
+	}
+
+	return session.Wait()
+}
+
+func (t *streamTransporter) Cleanup(ctx context.Context) error {
+	return nil
+}
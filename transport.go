@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/appleboy/com/random"
+	"github.com/pkg/sftp"
+)
+
+// Supported values for Config.Transport.
+const (
+	transportTar   = "tar"
+	transportSFTP  = "sftp"
+	transportRsync = "rsync"
+)
+
+// Transporter ships the files matched by Config.Source to target on a
+// single host. A Transporter is created once per host and Upload is called
+// once per Config.Target entry; Cleanup runs once the host's targets are
+// all done.
+type Transporter interface {
+	Upload(ctx context.Context, files fileList, target string) error
+	Cleanup(ctx context.Context) error
+}
+
+// newTransporter picks the Transporter for p.Config.Transport. tarPath is
+// the local archive built by Exec for the "tar" (default) backend; it is
+// ignored by the other backends.
+func newTransporter(host string, ssh remoteSession, p *Plugin, tarPath string) Transporter {
+	switch {
+	case p.Config.Transport == transportSFTP:
+		return &sftpTransporter{host: host, ssh: ssh, cfg: p.Config}
+	case p.Config.Transport == transportRsync:
+		return &rsyncTransporter{host: host, cfg: p.Config}
+	case p.Config.Incremental:
+		return &incrementalTarTransporter{host: host, ssh: ssh, plugin: p}
+	case p.Config.Streaming:
+		return &streamTransporter{host: host, ssh: ssh, plugin: p}
+	default:
+		return &tarTransporter{host: host, ssh: ssh, plugin: p, tarPath: tarPath}
+	}
+}
+
+// tarTransporter is the original behaviour: scp the locally built tarball
+// once, then untar it into each target in turn.
+type tarTransporter struct {
+	host    string
+	ssh     remoteSession
+	plugin  *Plugin
+	tarPath string
+
+	uploadOnce sync.Once
+	uploadErr  error
+}
+
+func (t *tarTransporter) Upload(ctx context.Context, files fileList, target string) error {
+	t.uploadOnce.Do(func() {
+		t.plugin.log(t.host, "scp file to server.")
+		t.uploadErr = t.ssh.Scp(t.tarPath, t.plugin.DestFile)
+	})
+	if t.uploadErr != nil {
+		return t.uploadErr
+	}
+
+	p := t.plugin
+	command := strings.Join(p.buildArgs(target), " ")
+	if p.Config.Encrypt {
+		command = fmt.Sprintf("age -d -i %s %s | %s", p.Config.RemoteIdentityPath, p.DestFile, command)
+	}
+	if p.Config.Debug {
+		fmt.Println("$", command)
+	}
+
+	p.log(t.host, "untar file", p.DestFile)
+	outStr, errStr, _, err := t.ssh.Run(command, p.Config.CommandTimeout)
+	if outStr != "" {
+		p.log(t.host, "output: ", outStr)
+	}
+	if errStr != "" {
+		p.log(t.host, "error: ", errStr)
+	}
+
+	return err
+}
+
+func (t *tarTransporter) Cleanup(ctx context.Context) error {
+	if t.uploadErr != nil {
+		return nil
+	}
+
+	return t.plugin.removeDestFile(t.host, t.ssh)
+}
+
+// sftpTransporter streams files.Source directly over the existing SSH
+// connection, so neither a local tarball nor a remote tar binary is needed.
+type sftpTransporter struct {
+	host string
+	ssh  remoteSession
+	cfg  Config
+
+	client *sftp.Client
+}
+
+func (t *sftpTransporter) Upload(ctx context.Context, files fileList, target string) error {
+	if t.client == nil {
+		conn, err := t.ssh.Client()
+		if err != nil {
+			return err
+		}
+
+		t.client, err = sftp.NewClient(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, src := range files.Source {
+		if err := t.uploadPath(src, target); err != nil {
+			return fmt.Errorf("sftp upload %s: %w", src, err)
+		}
+	}
+
+	return nil
+}
+
+func (t *sftpTransporter) uploadPath(src, target string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return t.uploadFile(src, target)
+	}
+
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		return t.uploadFile(p, target)
+	})
+}
+
+func (t *sftpTransporter) uploadFile(src, target string) error {
+	remotePath := path.Join(target, stripComponents(src, t.cfg.StripComponents))
+
+	if err := t.client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	if t.cfg.Overwrite || t.cfg.UnlinkFirst {
+		// best-effort: a missing file is not an error here.
+		_ = t.client.Remove(remotePath)
+	}
+
+	local, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	remote, err := t.client.Create(remotePath)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	_, err = io.Copy(remote, local)
+
+	return err
+}
+
+func (t *sftpTransporter) Cleanup(ctx context.Context) error {
+	if t.client == nil {
+		return nil
+	}
+
+	return t.client.Close()
+}
+
+// stripComponents drops the first n slash-separated components of path,
+// the sftp equivalent of tar's --strip-components.
+func stripComponents(p string, n int) string {
+	if n <= 0 {
+		return filepath.ToSlash(p)
+	}
+
+	parts := strings.Split(filepath.ToSlash(p), "/")
+	if n >= len(parts) {
+		return parts[len(parts)-1]
+	}
+
+	return strings.Join(parts[n:], "/")
+}
+
+// rsyncTransporter shells out to the local rsync binary over ssh, for
+// remotes where the plugin's own transports aren't a good fit.
+type rsyncTransporter struct {
+	host string
+	cfg  Config
+}
+
+func (t *rsyncTransporter) Upload(ctx context.Context, files fileList, target string) error {
+	if len(files.Source) == 0 {
+		return nil
+	}
+
+	// rsync shells out to the system ssh/rsync binaries directly, so it
+	// can't honour the socks5/http/bastion dialing resolveProxy picks for
+	// the plugin's own ssh-based transports, or Config.Password (there is
+	// no sshpass wiring here) - fail loudly rather than attempt a direct,
+	// keyless-or-unproxied connection that quietly ignores how the user
+	// configured reaching the host.
+	if proxyType, _ := resolveProxy(t.cfg, t.host); proxyType != "" {
+		return fmt.Errorf("transport %q does not support proxying to %s; configure ssh's own ProxyCommand or use the tar/sftp transport instead", transportRsync, t.host)
+	}
+
+	if t.cfg.Key == "" && t.cfg.KeyPath == "" {
+		return fmt.Errorf("transport %q requires key-based auth (Config.Key/Config.KeyPath); password-only auth is not supported", transportRsync)
+	}
+
+	sshCmd := fmt.Sprintf("ssh -p %s -o StrictHostKeyChecking=no", t.cfg.Port)
+	if t.cfg.KeyPath != "" {
+		sshCmd += fmt.Sprintf(" -i %s", t.cfg.KeyPath)
+	}
+
+	args := []string{"-a", "-e", sshCmd}
+	if t.cfg.Remove {
+		args = append(args, "--delete")
+	}
+	for _, pattern := range files.Ignore {
+		args = append(args, "--exclude", pattern)
+	}
+
+	args = append(args, files.Source...)
+	args = append(args, fmt.Sprintf("%s@%s:%s", t.cfg.Username, t.host, target))
+
+	cmd := exec.CommandContext(ctx, "rsync", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (t *rsyncTransporter) Cleanup(ctx context.Context) error {
+	return nil
+}
+
+// incrementalTarTransporter is the "tar" (default) backend's counterpart
+// for Config.Incremental: since the files to ship differ per host/target,
+// it builds and scps a small archive per Upload call instead of the one
+// shared archive Exec builds up front for the non-incremental case.
+type incrementalTarTransporter struct {
+	host   string
+	ssh    remoteSession
+	plugin *Plugin
+}
+
+func (t *incrementalTarTransporter) Upload(ctx context.Context, files fileList, target string) error {
+	if len(files.Source) == 0 {
+		t.plugin.log(t.host, "nothing changed under", target)
+		return nil
+	}
+
+	p := t.plugin
+	destFile := fmt.Sprintf("%s.tar.gz", random.String(10))
+	tarPath := filepath.Join(os.TempDir(), destFile)
+
+	args := buildArgs(tarPath, files)
+	cmd := exec.CommandContext(ctx, p.Config.TarExec, args...)
+	if p.Config.Debug {
+		fmt.Println("$", strings.Join(cmd.Args, " "))
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	defer os.Remove(tarPath)
+
+	if p.Config.Encrypt {
+		encPath, err := encryptArchive(tarPath, p.Config.EncryptRecipients)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(encPath)
+
+		tarPath = encPath
+		destFile += ".age"
+	}
+
+	remoteDest := fmt.Sprintf("%s%s", p.Config.TarTmpPath, destFile)
+
+	p.log(t.host, "scp file to server.")
+	if err := t.ssh.Scp(tarPath, remoteDest); err != nil {
+		return err
+	}
+	defer func() {
+		// best-effort: a leftover tmp archive is not worth failing the
+		// whole upload over.
+		_, _, _, _ = t.ssh.Run(rmcmd(remoteDest), p.Config.CommandTimeout)
+	}()
+
+	command := strings.Join(p.buildArgsFor(remoteDest, target), " ")
+	if p.Config.Encrypt {
+		command = fmt.Sprintf("age -d -i %s %s | %s", p.Config.RemoteIdentityPath, remoteDest, command)
+	}
+	if p.Config.Debug {
+		fmt.Println("$", command)
+	}
+
+	p.log(t.host, "untar file", remoteDest)
+	outStr, errStr, _, err := t.ssh.Run(command, p.Config.CommandTimeout)
+	if outStr != "" {
+		p.log(t.host, "output: ", outStr)
+	}
+	if errStr != "" {
+		p.log(t.host, "error: ", errStr)
+	}
+
+	return err
+}
+
+func (t *incrementalTarTransporter) Cleanup(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestStripComponents(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		n    int
+		want string
+	}{
+		{"no strip", "a/b/c.txt", 0, "a/b/c.txt"},
+		{"strip one", "a/b/c.txt", 1, "b/c.txt"},
+		{"strip all but base", "a/b/c.txt", 2, "c.txt"},
+		{"strip more than available", "a/b/c.txt", 5, "c.txt"},
+		{"single component", "c.txt", 1, "c.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripComponents(tt.path, tt.n); got != tt.want {
+				t.Errorf("stripComponents(%q, %d) = %q, want %q", tt.path, tt.n, got, tt.want)
+			}
+		})
+	}
+}